@@ -23,6 +23,9 @@ import (
 	"encoding/binary"
 )
 
+// RFC1035:4.1.4 - the maximum length of an expanded (dot-joined) domain name
+const max_name_len = 255
+
 const (
 	// Message header flag bitmasks; e.g. RFC1035:4.1.1
 	QRMask uint16 = 1 << 15  // 0b1000000000000000
@@ -70,6 +73,7 @@ const (
 	TXT   uint16 = 16
 	AAAA  uint16 = 28
 	SRV   uint16 = 33
+	OPT   uint16 = 41 // RFC6891 (EDNS0 pseudo-RR)
 	ANY   uint16 = 255
 
 	// Classes, "no obsolete" ;) RFC1035:3.2.4
@@ -115,6 +119,7 @@ var (
 		TXT:   "TXT",
 		AAAA:  "AAAA",
 		SRV:   "SRV",
+		OPT:   "OPT",
 		ANY:   "ANY",
 	}
 
@@ -165,30 +170,79 @@ func Rcode(u uint16) uint16 {
 
 // Parse byte sequence of [N][b1,b2,...bN] into labels; RFC1035:2.3.4,3.1
 // allow_ptr flag determines whether we follow "pointers" for compression.
-func parse_labels(i int, max_i int, b []byte, allow_ptr bool) (int,[]string) {
+//
+// Implemented as a loop rather than recursion: each compression pointer we
+// follow is recorded in "visited", so a pointer cycle is detected (and
+// rejected) rather than looping forever.
+func parse_labels(i int, max_i int, b []byte, allow_ptr bool) (int, []string, error) {
 
 	ptr_bits := uint8(0xc0)    // 0b11000000
 	idx_bits := uint16(0x3FFF) // 0b0011111111111111
 
-	var results = []string{}
-	
+	var results []string
+	visited := map[int]bool{}
+	expanded_len := 0
+
+	ret_i := -1 // position to hand back to our caller; set once, on the first pointer followed (or the terminator, if none)
+
 	for {
+		// Checked before the general out-of-range test below, since a
+		// character-string run (!allow_ptr) legitimately ends with i at
+		// max_i == len(b) - there is no terminating root label to stop on.
+		if (max_i > 0) && (i >= max_i) && (ret_i < 0) {
+			if !allow_ptr {
+				// Character-strings (e.g. TXT rdata) have no terminating
+				// root label - they simply fill max_i, so reaching it here
+				// is the normal end of data, not a malformed name.
+				return i, results, nil
+			}
+			return 0, nil, fmt.Errorf("parse_labels: ran past bound %d without a terminating label", max_i)
+		}
 
-		if (max_i>0) && (i>=max_i) { return max_i+1, results }
+		if i < 0 || i >= len(b) {
+			return 0, nil, fmt.Errorf("parse_labels: offset %d out of range (buffer length %d)", i, len(b))
+		}
+
+		if allow_ptr && ((b[i]&ptr_bits)==ptr_bits) {
+			if i+2 > len(b) {
+				return 0, nil, fmt.Errorf("parse_labels: truncated compression pointer at offset %d", i)
+			}
+			if visited[i] {
+				return 0, nil, fmt.Errorf("parse_labels: compression pointer loop at offset %d", i)
+			}
+			visited[i] = true
 
-		if (allow_ptr) && ((b[i]&ptr_bits)==ptr_bits) {
 			u16 := binary.BigEndian.Uint16(b[i:]) & idx_bits
-			i += 2
-			_, new_results := parse_labels(int(u16),max_i,b,allow_ptr)
-			return i, append(results, new_results...)
-		} else {
-			l := int(b[i])
-			i += 1
-			if l == 0 { return i, results }
-			new_string := string(b[i:i+l])
-			results = append(results, new_string)
-			i += l
+			if ret_i < 0 { ret_i = i+2 }
+			i = int(u16)
+			continue
+		}
+
+		l := int(b[i])
+		if l > 63 {
+			return 0, nil, fmt.Errorf("parse_labels: label length %d at offset %d exceeds 63 bytes", l, i)
+		}
+		i += 1
+
+		if l == 0 {
+			if ret_i < 0 { ret_i = i }
+			return ret_i, results, nil
+		}
+
+		if i+l > len(b) {
+			return 0, nil, fmt.Errorf("parse_labels: label of length %d at offset %d exceeds buffer", l, i)
+		}
+		if (max_i > 0) && (ret_i < 0) && (i+l > max_i) {
+			return 0, nil, fmt.Errorf("parse_labels: label of length %d at offset %d exceeds bound %d", l, i, max_i)
+		}
+
+		expanded_len += l+1 // +1 for the separating '.' (or length octet)
+		if expanded_len > max_name_len {
+			return 0, nil, fmt.Errorf("parse_labels: expanded name exceeds %d bytes", max_name_len)
 		}
+
+		results = append(results, string(b[i:i+l]))
+		i += l
 	}
 }
 
@@ -200,19 +254,25 @@ type DNSRRHeader struct {
 	Class uint16
 }
 
-func (h *DNSRRHeader) FromBytes(i int, b []byte) int {
+func (h *DNSRRHeader) FromBytes(i int, b []byte) (int, error) {
 	var l []string
+	var err error
 
-	i, l = parse_labels(i,-1,b,true)
+	i, l, err = parse_labels(i,-1,b,true)
+	if err != nil { return 0, fmt.Errorf("DNSRRHeader.FromBytes: %w", err) }
 	h.Name = strings.Join(l,".")
 
+	if i+4 > len(b) {
+		return 0, fmt.Errorf("DNSRRHeader.FromBytes: truncated header at offset %d", i)
+	}
+
 	h.Type = binary.BigEndian.Uint16(b[i:])
 	i += 2
 
 	h.Class = binary.BigEndian.Uint16(b[i:])
 	i += 2
 
-	return i
+	return i, nil
 }
 
 // DNS resource record
@@ -226,8 +286,15 @@ type DNSRR struct {
 	raw_payload []byte
 }
 
-func (rr *DNSRR) FromBytes(i int, b []byte) int {
-	i = rr.Header.FromBytes(i,b)
+func (rr *DNSRR) FromBytes(i int, b []byte) (int, error) {
+	var err error
+
+	i, err = rr.Header.FromBytes(i,b)
+	if err != nil { return 0, err }
+
+	if i+6 > len(b) {
+		return 0, fmt.Errorf("DNSRR.FromBytes: truncated RR at offset %d", i)
+	}
 
 	rr.TTL = binary.BigEndian.Uint32(b[i:])
 	i += 4
@@ -235,46 +302,69 @@ func (rr *DNSRR) FromBytes(i int, b []byte) int {
 	rdlen := int( binary.BigEndian.Uint16(b[i:]) )
 	i += 2
 
+	if i+rdlen > len(b) {
+		return 0, fmt.Errorf("DNSRR.FromBytes: rdata of length %d at offset %d exceeds buffer", rdlen, i)
+	}
+
 	rr.raw_payload = b[i:i+rdlen] // slice backed by parent message's RawMessage
 
 	var l = []string{}
 
 	switch rr.Header.Type {
 		case A:
+			if rdlen < 4 {
+				return 0, fmt.Errorf("DNSRR.FromBytes: A rdata too short (%d bytes)", rdlen)
+			}
 			rr.Payload = DNSPayloadA {
-				IP: net.IPv4(b[i+0],b[i+1],b[i+2],b[i+3]), // FIX THIS: BOUNDS CHECK
+				IP: net.IPv4(b[i+0],b[i+1],b[i+2],b[i+3]),
 			}
 
 		case PTR:
-			_,l = parse_labels(i, i+rdlen, b, true)
+			_,l,err = parse_labels(i, i+rdlen, b, true)
+			if err != nil { return 0, fmt.Errorf("DNSRR.FromBytes: PTR: %w", err) }
 			rr.Payload = DNSPayloadPtr{
 				Text: strings.Join(l, "."),
 			}
 
 		case TXT:
-			_,l = parse_labels(i, i+rdlen, b, false)
+			_,l,err = parse_labels(i, i+rdlen, b, false)
+			if err != nil { return 0, fmt.Errorf("DNSRR.FromBytes: TXT: %w", err) }
 			rr.Payload = DNSPayloadTxt{
 				Text: strings.Join(l, " "),
 			}
 
 		case AAAA:
+			if rdlen < 16 {
+				return 0, fmt.Errorf("DNSRR.FromBytes: AAAA rdata too short (%d bytes)", rdlen)
+			}
 			rr.Payload = DNSPayloadAAAA {
-				IP: b[i:i+16], // FIX THIS: BOUNDS CHECK
+				IP: b[i:i+16],
 			}
 
 		case SRV:
-			_,l = parse_labels(i+6, i+rdlen, b, true) // i+6: labels @ i+(3xU16)
+			if rdlen < 6 {
+				return 0, fmt.Errorf("DNSRR.FromBytes: SRV rdata too short (%d bytes)", rdlen)
+			}
+			_,l,err = parse_labels(i+6, i+rdlen, b, true) // i+6: labels @ i+(3xU16)
+			if err != nil { return 0, fmt.Errorf("DNSRR.FromBytes: SRV: %w", err) }
 			rr.Payload = DNSPayloadSrv{
 				Priority: binary.BigEndian.Uint16(b[i:]),
 				Weight: binary.BigEndian.Uint16(b[i+2:]),
 				Port: binary.BigEndian.Uint16(b[i+4:]),
 				Text: strings.Join(l, " "),
 			}
+
+		case OPT:
+			opt := DNSPayloadOPT{}
+			if err = opt.FromBytes(i, i+rdlen, b, rr.Header.Class, rr.TTL); err != nil {
+				return 0, fmt.Errorf("DNSRR.FromBytes: OPT: %w", err)
+			}
+			rr.Payload = opt
 	}
 
 	i += rdlen
 
-	return i
+	return i, nil
 }
 
 // DNS resource record "payloads" - type specific data for rr's
@@ -318,19 +408,23 @@ type DNSMessageHeader struct {
 	AdditionalCount uint16
 }
 
-func (h *DNSMessageHeader) FromBytes(i int, b []byte) int {
+func (h *DNSMessageHeader) FromBytes(i int, b []byte) (int, error) {
 	ptrs := []*uint16 {
 		&h.Identification, &h.Flags,
 		&h.QuestionCount, &h.AnswerCount,
 		&h.AuthorityCount, &h.AdditionalCount,
 	}
 
+	if i+2*len(ptrs) > len(b) {
+		return 0, fmt.Errorf("DNSMessageHeader.FromBytes: truncated header at offset %d (len %d)", i, len(b))
+	}
+
 	for _,ptr := range(ptrs) {
 		*ptr = binary.BigEndian.Uint16(b[i:])
 		i += 2
 	}
 
-	return i
+	return i, nil
 }
 
 func (h *DNSMessageHeader) String() string {
@@ -363,35 +457,42 @@ type DNSMessage struct {
 	raw_msg []byte
 }
 
-func (m *DNSMessage) FromBytes(bytes []byte) {
+func (m *DNSMessage) FromBytes(bytes []byte) error {
 	m.raw_msg = make([]byte, len(bytes))
 	copy(m.raw_msg, bytes)
 
-	i := m.Header.FromBytes(0,m.raw_msg)
+	i, err := m.Header.FromBytes(0,m.raw_msg)
+	if err != nil { return err }
 
 	for j := uint16(0); j<m.Header.QuestionCount; j++ {
 		h := DNSRRHeader{}
-		i = h.FromBytes(i,m.raw_msg)
+		i, err = h.FromBytes(i,m.raw_msg)
+		if err != nil { return fmt.Errorf("DNSMessage.FromBytes: question %d: %w", j, err) }
 		m.Question = append(m.Question, h)
 	}
 
 	for j := uint16(0); j<m.Header.AnswerCount; j++ {
 		rr := DNSRR{}
-		i = rr.FromBytes(i,m.raw_msg)
+		i, err = rr.FromBytes(i,m.raw_msg)
+		if err != nil { return fmt.Errorf("DNSMessage.FromBytes: answer %d: %w", j, err) }
 		m.Answer = append(m.Answer, rr)
 	}
 
 	for j := uint16(0); j<m.Header.AuthorityCount; j++ {
 		rr := DNSRR{}
-		i = rr.FromBytes(i,m.raw_msg)
+		i, err = rr.FromBytes(i,m.raw_msg)
+		if err != nil { return fmt.Errorf("DNSMessage.FromBytes: authority %d: %w", j, err) }
 		m.Authority = append(m.Authority, rr)
 	}
 
 	for j := uint16(0); j<m.Header.AdditionalCount; j++ {
 		rr := DNSRR{}
-		i = rr.FromBytes(i,m.raw_msg)
+		i, err = rr.FromBytes(i,m.raw_msg)
+		if err != nil { return fmt.Errorf("DNSMessage.FromBytes: additional %d: %w", j, err) }
 		m.Additional = append(m.Additional, rr)
 	}
+
+	return nil
 }
 
 func (m *DNSMessage) Print() {
@@ -456,6 +557,9 @@ func rr_print(preamble string, in interface{}) {
 				case SRV:
 					t = fmt.Sprintf("SVR ")
 
+				case OPT:
+					t = fmt.Sprintf("OPT ")
+
 				default:
 					t = fmt.Sprintf("UNK ")
 			}
@@ -479,6 +583,9 @@ func rr_print(preamble string, in interface{}) {
 				case DNSPayloadSrv:
 					t, p = fmt.Sprintf("SVR "), fmt.Sprintf(", payload=%+v", payload)
 
+				case DNSPayloadOPT:
+					t, p = fmt.Sprintf("OPT "), fmt.Sprintf(", payload=%+v", payload)
+
 				default:
 					t, p = fmt.Sprintf("UNK "), fmt.Sprintf(", payload={%d bytes rdata}", len(x.raw_payload))
 			}
@@ -0,0 +1,393 @@
+package main
+
+/*
+	Active mDNS service discovery on top of msg_loop: Browse() watches a
+	service type for PTR/SRV/TXT/A/AAAA records and reports ServiceEntry
+	values as they appear or are updated; an RFC6762 goodbye packet (TTL 0)
+	removes the instance from the cache, and no entry is sent for its
+	removal. Lookup() resolves one specific instance.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RFC6762:5.2 - repeated queries back off, capped at 60 minutes
+	min_query_interval = time.Second
+	max_query_interval = 60 * time.Minute
+
+	// RFC6762:10.1 - records are considered stale at 80% of their TTL,
+	// but we simply expire from the cache at 100% for now.
+	cache_sweep_interval = time.Second
+)
+
+// A single discovered (or resolved) service instance.
+
+type ServiceEntry struct {
+	Instance string
+	Service  string
+	Domain   string
+	Host     string
+	Port     uint16
+	IPv4     []net.IP
+	IPv6     []net.IP
+	TXT      map[string]string
+	TTL      uint32
+	Iface    *net.Interface
+}
+
+// Cache entry: a ServiceEntry plus the time it should be evicted.
+
+type cacheEntry struct {
+	entry  ServiceEntry
+	expiry time.Time
+}
+
+// Browser actively queries for, and caches, mDNS service instances.
+
+type Browser struct {
+	ifaces []net.Interface
+
+	stop_chan chan bool
+	dnsi_chan chan DNSMsgInfo
+	send4, send6 chan []byte
+	wait_group sync.WaitGroup
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry // keyed by "instance.service.domain"
+}
+
+// NewBrowser creates a Browser that will query/listen on the given interfaces
+// (an empty slice means "all multicast-capable interfaces").
+
+func NewBrowser(ifaces []net.Interface) *Browser {
+	return &Browser{
+		ifaces:    ifaces,
+		stop_chan: make(chan bool),
+		dnsi_chan: make(chan DNSMsgInfo),
+		send4:     make(chan []byte, 8),
+		send6:     make(chan []byte, 8),
+		cache:     make(map[string]*cacheEntry),
+	}
+}
+
+// start launches the underlying IPv4/IPv6 message loops, plus the cache
+// sweeper; safe to call once.
+
+func (br *Browser) start() error {
+	mDNSAddr4, err := net.ResolveUDPAddr("udp4", "224.0.0.251:5353")
+	if err != nil { return err }
+
+	mDNSAddr6, err := net.ResolveUDPAddr("udp6", "[ff02::fb]:5353")
+	if err != nil { return err }
+
+	ifaces := br.ifaces
+	if len(ifaces) == 0 {
+		all, err := net.Interfaces()
+		if err != nil { return err }
+		for _, iface := range all {
+			if (iface.Flags & net.FlagMulticast) == net.FlagMulticast {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+
+	br.wait_group.Add(1)
+	go func() {
+		defer br.wait_group.Done()
+		ip4_msg_loop(mDNSAddr4, mDNSAddr4, ifaces, br.stop_chan, br.dnsi_chan, br.send4)
+	}()
+
+	br.wait_group.Add(1)
+	go func() {
+		defer br.wait_group.Done()
+		ip6_msg_loop(mDNSAddr6, mDNSAddr6, ifaces, br.stop_chan, br.dnsi_chan, br.send6)
+	}()
+
+	br.wait_group.Add(1)
+	go func() {
+		defer br.wait_group.Done()
+		br.sweepLoop()
+	}()
+
+	return nil
+}
+
+// sweepLoop periodically evicts cache entries past their expiry (RFC6762:10.1);
+// the goodbye-packet path in handleMessage handles the TTL=0 case separately.
+
+func (br *Browser) sweepLoop() {
+	ticker := time.NewTicker(cache_sweep_interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+			case <-br.stop_chan:
+				return
+
+			case <-ticker.C:
+				br.sweep()
+		}
+	}
+}
+
+func (br *Browser) sweep() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	now := time.Now()
+	for key, ce := range br.cache {
+		if !ce.expiry.IsZero() && now.After(ce.expiry) {
+			delete(br.cache, key)
+		}
+	}
+}
+
+// Close stops the message loops and waits for them to exit.
+
+func (br *Browser) Close() {
+	close(br.stop_chan)
+
+	// Keep draining dnsi_chan while waiting, so a loop blocked on sending a
+	// message can get back to checking stop_chan.
+	done := make(chan struct{})
+	go func() { br.wait_group.Wait(); close(done) }()
+	for {
+		select {
+			case <-done:
+				return
+
+			case <-br.dnsi_chan:
+		}
+	}
+}
+
+// Browse queries for instances of "service" (e.g. "_http._tcp.local.") and
+// sends a ServiceEntry to entries each time a matching record set changes.
+// Browse blocks until ctx is cancelled.
+
+func (br *Browser) Browse(ctx context.Context, service string, entries chan<- ServiceEntry) error {
+	if err := br.start(); err != nil { return err }
+	defer br.Close()
+
+	query, err := BuildQuery([]string{service}, PTR)
+	if err != nil { return err }
+
+	interval := time.Duration(0)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+			case <-ctx.Done():
+				return nil
+
+			case <-timer.C:
+				br.send4 <- query
+				br.send6 <- query
+
+				// RFC6762:5.2 - at least double the interval each time, capped
+				if interval < min_query_interval {
+					interval = min_query_interval
+				} else if interval *= 2; interval > max_query_interval {
+					interval = max_query_interval
+				}
+				timer.Reset(interval)
+
+			case dnsi := <-br.dnsi_chan:
+				if se := br.handleMessage(service, dnsi); se != nil {
+					entries <- *se
+				}
+		}
+	}
+}
+
+// Lookup resolves a single known instance of a service, blocking until it is
+// found or ctx is cancelled.
+
+func (br *Browser) Lookup(ctx context.Context, instance, service, domain string) (*ServiceEntry, error) {
+	if err := br.start(); err != nil { return nil, err }
+	defer br.Close()
+
+	full := strings.TrimSuffix(instance, ".") + "." +
+		strings.TrimSuffix(service, ".") + "." +
+		strings.TrimSuffix(domain, ".") + "."
+
+	srv_query, err := BuildQuery([]string{full}, SRV)
+	if err != nil { return nil, err }
+
+	txt_query, err := BuildQuery([]string{full}, TXT)
+	if err != nil { return nil, err }
+
+	for _, q := range [][]byte{srv_query, txt_query} {
+		br.send4 <- q
+		br.send6 <- q
+	}
+
+	for {
+		select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+
+			case dnsi := <-br.dnsi_chan:
+				if se := br.handleMessage(strings.TrimSuffix(service, ".")+"."+strings.TrimSuffix(domain, "."), dnsi); se != nil && se.Instance == instance {
+					return se, nil
+				}
+		}
+	}
+}
+
+// handleMessage folds the RRs of one received message into the cache, and
+// returns the updated entry for "service" if this message touched one.
+
+func (br *Browser) handleMessage(service string, dnsi DNSMsgInfo) *ServiceEntry {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	touchedKeys := map[string]bool{}
+
+	rrs := append(append([]DNSRR{}, dnsi.msg.Answer...), dnsi.msg.Additional...)
+
+	for _, rr := range rrs {
+		switch payload := rr.Payload.(type) {
+
+			case DNSPayloadPtr:
+				target := strings.TrimSuffix(payload.Text, ".")
+				if rr.TTL == 0 { // RFC6762:10.1 goodbye packet
+					delete(br.cache, target)
+					continue
+				}
+				ce := br.getOrCreate(target)
+				ce.entry.Iface = dnsi.iface
+				ce.expiry = time.Now().Add(time.Duration(rr.TTL) * time.Second)
+				ce.entry.TTL = rr.TTL
+				touchedKeys[target] = true
+
+			case DNSPayloadSrv:
+				key := strings.TrimSuffix(rr.Header.Name, ".")
+				ce := br.getOrCreate(key)
+				ce.entry.Host = strings.TrimSuffix(strings.ReplaceAll(payload.Text, " ", "."), ".")
+				ce.entry.Port = payload.Port
+				touchedKeys[key] = true
+
+			case DNSPayloadTxt:
+				key := strings.TrimSuffix(rr.Header.Name, ".")
+				ce := br.getOrCreate(key)
+				ce.entry.TXT = parseTXT(payload.Text)
+				touchedKeys[key] = true
+
+			case DNSPayloadA:
+				for _, key := range br.addAddress(strings.TrimSuffix(rr.Header.Name, "."), payload.IP, nil) {
+					touchedKeys[key] = true
+				}
+
+			case DNSPayloadAAAA:
+				for _, key := range br.addAddress(strings.TrimSuffix(rr.Header.Name, "."), nil, payload.IP) {
+					touchedKeys[key] = true
+				}
+		}
+	}
+
+	// Report whichever instance this message actually updated (not just any
+	// cache entry that happens to match), if it now belongs to the service
+	// the caller is browsing/looking up.
+	var touched *ServiceEntry
+	for key := range touchedKeys {
+		ce, ok := br.cache[key]
+		if !ok { continue }
+
+		if ce.entry.Service+"."+ce.entry.Domain == strings.TrimSuffix(service, ".") ||
+			strings.HasSuffix(key, strings.TrimSuffix(service, ".")) {
+			entry := ce.entry
+			touched = &entry
+		}
+	}
+
+	return touched
+}
+
+func (br *Browser) getOrCreate(key string) *cacheEntry {
+	ce, ok := br.cache[key]
+	if !ok {
+		instance, service, domain := splitInstanceName(key)
+		ce = &cacheEntry{entry: ServiceEntry{Instance: instance, Service: service, Domain: domain}}
+		br.cache[key] = ce
+	}
+	return ce
+}
+
+// addAddress appends ip4/ip6 to every cache entry currently resolving to
+// "host", and returns the keys of the entries it touched.
+
+func (br *Browser) addAddress(host string, ip4, ip6 net.IP) []string {
+	var touched []string
+	for key, ce := range br.cache {
+		if ce.entry.Host != host { continue }
+		if ip4 != nil { ce.entry.IPv4 = append(ce.entry.IPv4, ip4) }
+		if ip6 != nil { ce.entry.IPv6 = append(ce.entry.IPv6, ip6) }
+		touched = append(touched, key)
+	}
+	return touched
+}
+
+// splitInstanceName splits "My Printer._http._tcp.local" into its instance,
+// service and domain parts.
+
+func splitInstanceName(name string) (instance, service, domain string) {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) < 2 { return name, "", "" }
+	instance = parts[0]
+
+	rparts := strings.Split(parts[1], ".")
+	if len(rparts) < 2 { return instance, parts[1], "" }
+
+	domain = rparts[len(rparts)-1]
+	service = strings.Join(rparts[:len(rparts)-1], ".")
+	return
+}
+
+// parseTXT turns a space-joined "key=value key2=value2" string (see
+// DNSPayloadTxt) into a map; entries with no "=" are kept with an empty value.
+
+func parseTXT(text string) map[string]string {
+	m := make(map[string]string)
+	if text == "" { return m }
+	for _, kv := range strings.Split(text, " ") {
+		if kv == "" { continue }
+		if idx := strings.IndexByte(kv, '='); idx >= 0 {
+			m[kv[:idx]] = kv[idx+1:]
+		} else {
+			m[kv] = ""
+		}
+	}
+	return m
+}
+
+// BuildQuery encodes a single-question-per-name mDNS query message, via
+// DNSMessage.ToBytes (RFC1035:4.1.2).
+
+func BuildQuery(names []string, qtype uint16) ([]byte, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("BuildQuery: no names specified")
+	}
+	if len(names) > 0xFFFF {
+		return nil, fmt.Errorf("BuildQuery: too many questions (%d)", len(names))
+	}
+
+	m := DNSMessage{}
+	for _, name := range names {
+		m.Question = append(m.Question, DNSRRHeader{
+			Name:  strings.TrimSuffix(name, "."),
+			Type:  qtype,
+			Class: IN,
+		})
+	}
+
+	return m.ToBytes()
+}
@@ -0,0 +1,255 @@
+package main
+
+/*
+	"client" subcommand: a small conventional (unicast) DNS client built on
+	the same DNSMessage encoder/decoder as the mDNS path:
+
+		zeroconfscan client @server query NAME TYPE
+		zeroconfscan client [-tsig name:base64secret] @server update ZONE add|del NAME TTL TYPE RDATA
+
+	"update" implements RFC2136 dynamic updates, optionally TSIG-signed
+	(RFC2845).
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const client_timeout = 5 * time.Second
+
+var stringToType = func() map[string]uint16 {
+	m := make(map[string]uint16, len(TypeToString))
+	for t, s := range TypeToString { m[s] = t }
+	return m
+}()
+
+func parseRRType(s string) (uint16, error) {
+	if t, ok := stringToType[strings.ToUpper(s)]; ok { return t, nil }
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil { return uint16(n), nil }
+	return 0, fmt.Errorf("client: unknown RR type %q", s)
+}
+
+// runClient implements the "client" subcommand.
+
+func runClient(args []string) error {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	proto := fs.String("proto", "udp", "Transport: \"udp\" or \"tcp\".")
+	tsig_arg := fs.String("tsig", "", "TSIG key as \"name:base64secret\", used to sign update messages.")
+	fs.Parse(args)
+
+	rest := fs.Args()
+	if len(rest) < 2 {
+		return fmt.Errorf("usage: client @server query NAME TYPE | client [-tsig name:base64secret] @server update ZONE add|del NAME TTL TYPE RDATA")
+	}
+
+	addr, err := resolveServerAddr(rest[0])
+	if err != nil { return err }
+
+	var tsig_key *TSIGKey
+	if *tsig_arg != "" {
+		if tsig_key, err = parseTSIGArg(*tsig_arg); err != nil { return err }
+	}
+
+	switch rest[1] {
+		case "query":
+			if len(rest) != 4 {
+				return fmt.Errorf("usage: client @server query NAME TYPE")
+			}
+			return clientQuery(addr, *proto, rest[2], rest[3])
+
+		case "update":
+			if len(rest) != 8 {
+				return fmt.Errorf("usage: client @server update ZONE add|del NAME TTL TYPE RDATA")
+			}
+			return clientUpdate(addr, *proto, tsig_key, rest[2], rest[3], rest[4], rest[5], rest[6], rest[7])
+
+		default:
+			return fmt.Errorf("client: unknown subcommand %q (want \"query\" or \"update\")", rest[1])
+	}
+}
+
+func resolveServerAddr(s string) (string, error) {
+	s = strings.TrimPrefix(s, "@")
+	if s == "" {
+		return "", fmt.Errorf("client: empty server address")
+	}
+
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		return net.JoinHostPort(host, port), nil
+	}
+
+	// No port: SplitHostPort fails the same way for a bare IPv6 literal
+	// ("::1") and a bracketed one with no port ("[::1]"), so strip any
+	// brackets before letting JoinHostPort re-add them as needed.
+	host := strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	return net.JoinHostPort(host, "53"), nil
+}
+
+func clientQuery(addr, proto, name, typeStr string) error {
+	qtype, err := parseRRType(typeStr)
+	if err != nil { return err }
+
+	m := DNSMessage{
+		Header: DNSMessageHeader{
+			Identification: uint16(rand.Intn(0x10000)),
+			Flags:          RDMask,
+		},
+		Question: []DNSRRHeader{{Name: strings.TrimSuffix(name, "."), Type: qtype, Class: IN}},
+	}
+
+	q, err := m.ToBytes()
+	if err != nil { return err }
+
+	resp, err := sendRecv(addr, proto, q)
+	if err != nil { return err }
+
+	rm := DNSMessage{}
+	if err := rm.FromBytes(resp); err != nil {
+		return fmt.Errorf("client: malformed response: %w", err)
+	}
+
+	rm.Print()
+	return nil
+}
+
+func clientUpdate(addr, proto string, key *TSIGKey, zone, mode, name, ttlStr, typeStr, rdataStr string) error {
+	ttl, err := strconv.ParseUint(ttlStr, 10, 32)
+	if err != nil { return fmt.Errorf("client: invalid TTL %q: %w", ttlStr, err) }
+
+	rtype, err := parseRRType(typeStr)
+	if err != nil { return err }
+
+	var class uint16
+	var payload interface{}
+
+	switch mode {
+		case "add":
+			class = IN
+			if payload, err = buildUpdateRRPayload(rtype, rdataStr); err != nil { return err }
+
+		case "del":
+			class = ANY // RFC2136:2.5.2 - delete an RRset: class ANY, TTL 0, RDLENGTH 0
+			ttl = 0
+
+		default:
+			return fmt.Errorf("client: update mode must be \"add\" or \"del\", got %q", mode)
+	}
+
+	m := DNSMessage{
+		Header: DNSMessageHeader{
+			Identification: uint16(rand.Intn(0x10000)),
+			Flags:          uint16(UPDATE) << 11, // RFC2136:2.2 - Zone/Update opcode
+		},
+		Question: []DNSRRHeader{{Name: strings.TrimSuffix(zone, "."), Type: SOA, Class: IN}}, // "Zone" section
+		Authority: []DNSRR{{ // "Update" section (RFC2136 reuses the Authority slot)
+			Header:  DNSRRHeader{Name: strings.TrimSuffix(name, "."), Type: rtype, Class: class},
+			TTL:     uint32(ttl),
+			Payload: payload,
+		}},
+	}
+
+	unsigned, err := m.ToBytes()
+	if err != nil { return err }
+
+	wire := unsigned
+	if key != nil {
+		if wire, err = signTSIG(unsigned, m.Header.Identification, key); err != nil { return err }
+	}
+
+	resp, err := sendRecv(addr, proto, wire)
+	if err != nil { return err }
+
+	rm := DNSMessage{}
+	if err := rm.FromBytes(resp); err != nil {
+		return fmt.Errorf("client: malformed response: %w", err)
+	}
+
+	fmt.Printf("Update response: %s\n", rm.Header.String())
+	return nil
+}
+
+func buildUpdateRRPayload(rtype uint16, rdata string) (interface{}, error) {
+	switch rtype {
+		case A:
+			ip := net.ParseIP(rdata)
+			if ip == nil || ip.To4() == nil {
+				return nil, fmt.Errorf("client: invalid A rdata %q", rdata)
+			}
+			return DNSPayloadA{IP: ip}, nil
+
+		case AAAA:
+			ip := net.ParseIP(rdata)
+			if ip == nil {
+				return nil, fmt.Errorf("client: invalid AAAA rdata %q", rdata)
+			}
+			return DNSPayloadAAAA{IP: ip}, nil
+
+		case PTR:
+			return DNSPayloadPtr{Text: rdata}, nil
+
+		case TXT:
+			return DNSPayloadTxt{Text: rdata}, nil
+
+		case SRV:
+			fields := strings.Fields(rdata)
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("client: SRV rdata must be \"priority weight port target\", got %q", rdata)
+			}
+			priority, err1 := strconv.ParseUint(fields[0], 10, 16)
+			weight, err2 := strconv.ParseUint(fields[1], 10, 16)
+			port, err3 := strconv.ParseUint(fields[2], 10, 16)
+			if err1 != nil || err2 != nil || err3 != nil {
+				return nil, fmt.Errorf("client: invalid SRV rdata %q", rdata)
+			}
+			return DNSPayloadSrv{Priority: uint16(priority), Weight: uint16(weight), Port: uint16(port), Text: fields[3]}, nil
+
+		default:
+			return nil, fmt.Errorf("client: update RDATA for type %s not supported", TypeToString[rtype])
+	}
+}
+
+// sendRecv sends "msg" to addr over proto ("udp" or "tcp") and returns the
+// raw response bytes, framing/deframing the RFC1035:4.2.2 TCP length prefix.
+
+func sendRecv(addr, proto string, msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(proto, addr, client_timeout)
+	if err != nil { return nil, fmt.Errorf("client: dial %s: %w", addr, err) }
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(client_timeout))
+
+	if proto == "tcp" {
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(msg)))
+		if _, err := conn.Write(append(length, msg...)); err != nil {
+			return nil, fmt.Errorf("client: write: %w", err)
+		}
+
+		r := bufio.NewReader(conn)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return nil, fmt.Errorf("client: read length prefix: %w", err)
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(r, resp); err != nil {
+			return nil, fmt.Errorf("client: read response: %w", err)
+		}
+		return resp, nil
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("client: write: %w", err)
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil { return nil, fmt.Errorf("client: read: %w", err) }
+	return buf[:n], nil
+}
@@ -0,0 +1,64 @@
+package main
+
+/*
+	Fuzz target for DNSMessage.FromBytes() (DNS.go): the parser must return an
+	error on malformed input rather than panic (e.g. index out of range) -
+	see parse_labels()'s bounds/loop checks. Seeded with the valid fixtures
+	from encode_test.go plus hand-built malformed packets covering the
+	failure modes parse_labels()/FromBytes() guard against.
+*/
+
+import "testing"
+
+func FuzzDNSMessageFromBytes(f *testing.F) {
+	f.Add(capturedQuery)
+	f.Add(capturedResponse)
+	f.Add(capturedTXT)
+
+	// Empty / too short for a header.
+	f.Add([]byte{})
+	f.Add([]byte{0x00, 0x00, 0x00})
+
+	// Header claims a question the buffer doesn't contain.
+	f.Add([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+
+	// Label length byte (100, top bits 01) exceeds the 63-byte cap.
+	f.Add(append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, 100))
+
+	// Two-byte compression pointer (0xc0 0x0c) pointing at itself: a loop.
+	f.Add([]byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xc0, 0x0c,
+	})
+
+	// Truncated compression pointer (only one byte present).
+	f.Add([]byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xc0,
+	})
+
+	// RR header present but truncated before TYPE/CLASS.
+	f.Add([]byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x01,
+	})
+
+	// Answer RR claims an rdlen longer than the remaining buffer.
+	f.Add([]byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x78, 0xff, 0xff,
+	})
+
+	// TXT answer whose character-string length byte (10) claims more than
+	// the RR's own rdlen (2) - must error, not read into trailing bytes.
+	f.Add([]byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x78, 0x00, 0x02,
+		10, 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a',
+	})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := DNSMessage{}
+		_ = m.FromBytes(data) // must not panic; an error is an acceptable outcome
+	})
+}
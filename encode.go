@@ -0,0 +1,210 @@
+package main
+
+/*
+	Encoding path symmetric to DNS.go's FromBytes parsing: ToBytes() methods
+	on DNSMessage and its constituent types, with RFC1035:4.1.4 domain name
+	compression (a name/suffix -> offset dictionary built up as records are
+	emitted, using the 0xc0 pointer form parse_labels() already understands).
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nameCompressor remembers the offset (from the start of the message) at
+// which each previously-emitted name (or name suffix) was written, so later
+// occurrences can be replaced with a two-byte pointer.
+
+type nameCompressor struct {
+	offsets map[string]uint16
+}
+
+func newNameCompressor() *nameCompressor {
+	return &nameCompressor{offsets: make(map[string]uint16)}
+}
+
+// encode appends "name" to *buf, compressing against any suffix already
+// written via an earlier call.
+
+func (c *nameCompressor) encode(name string, buf *[]byte) {
+	ptr_bits := uint16(0xc000) // 0b1100000000000000
+
+	name = strings.TrimSuffix(name, ".")
+
+	for name != "" {
+		if off, ok := c.offsets[name]; ok {
+			u16 := ptr_bits | off
+			*buf = append(*buf, byte(u16>>8), byte(u16))
+			return
+		}
+
+		// Only names at an offset representable in 14 bits can be pointed to
+		if len(*buf) <= 0x3FFF {
+			c.offsets[name] = uint16(len(*buf))
+		}
+
+		label, rest := splitFirstLabel(name)
+		*buf = append(*buf, byte(len(label)))
+		*buf = append(*buf, []byte(label)...)
+		name = rest
+	}
+
+	*buf = append(*buf, 0)
+}
+
+func splitFirstLabel(name string) (label, rest string) {
+	idx := strings.IndexByte(name, '.')
+	if idx < 0 { return name, "" }
+	return name[:idx], name[idx+1:]
+}
+
+// DNSMessageHeader
+
+func (h *DNSMessageHeader) ToBytes(buf *[]byte) {
+	for _, v := range []uint16{
+		h.Identification, h.Flags,
+		h.QuestionCount, h.AnswerCount,
+		h.AuthorityCount, h.AdditionalCount,
+	} {
+		*buf = append(*buf, byte(v>>8), byte(v))
+	}
+}
+
+// DNSRRHeader (also used standalone for question-section entries)
+
+func (h *DNSRRHeader) ToBytes(buf *[]byte, comp *nameCompressor) {
+	comp.encode(h.Name, buf)
+	*buf = append(*buf, byte(h.Type>>8), byte(h.Type), byte(h.Class>>8), byte(h.Class))
+}
+
+// DNSRR
+
+func (rr *DNSRR) ToBytes(buf *[]byte, comp *nameCompressor) error {
+	// OPT repurposes the header's CLASS/TTL fields (RFC6891:6.1.3); keep
+	// them in sync with the decoded payload before the header is emitted.
+	if opt, ok := rr.Payload.(DNSPayloadOPT); ok {
+		rr.Header.Class = opt.UDPSize
+		rr.TTL = uint32(opt.ExtRcode)<<24 | uint32(opt.Version)<<16
+		if opt.DO { rr.TTL |= 0x8000 }
+	}
+
+	rr.Header.ToBytes(buf, comp)
+
+	*buf = append(*buf, byte(rr.TTL>>24), byte(rr.TTL>>16), byte(rr.TTL>>8), byte(rr.TTL))
+
+	rdlen_pos := len(*buf)
+	*buf = append(*buf, 0, 0) // placeholder, patched below
+
+	rdata_start := len(*buf)
+
+	switch payload := rr.Payload.(type) {
+		case DNSPayloadA:
+			ip4 := payload.IP.To4()
+			if ip4 == nil {
+				return fmt.Errorf("DNSRR.ToBytes: A record has no IPv4 address (%v)", payload.IP)
+			}
+			*buf = append(*buf, ip4...)
+
+		case DNSPayloadAAAA:
+			ip6 := payload.IP.To16()
+			if ip6 == nil {
+				return fmt.Errorf("DNSRR.ToBytes: AAAA record has no IPv6 address (%v)", payload.IP)
+			}
+			*buf = append(*buf, ip6...)
+
+		case DNSPayloadPtr:
+			comp.encode(payload.Text, buf)
+
+		case DNSPayloadTxt:
+			for _, s := range strings.Split(payload.Text, " ") {
+				*buf = append(*buf, byte(len(s)))
+				*buf = append(*buf, []byte(s)...)
+			}
+
+		case DNSPayloadSrv:
+			*buf = append(*buf, byte(payload.Priority>>8), byte(payload.Priority))
+			*buf = append(*buf, byte(payload.Weight>>8), byte(payload.Weight))
+			*buf = append(*buf, byte(payload.Port>>8), byte(payload.Port))
+			comp.encode(strings.ReplaceAll(payload.Text, " ", "."), buf)
+
+		case DNSPayloadOPT:
+			for _, opt := range payload.Options {
+				opt.ToBytes(buf)
+			}
+
+		case nil:
+			// No decoded payload (e.g. an unrecognised type read via FromBytes):
+			// re-emit the raw rdata unchanged.
+			*buf = append(*buf, rr.raw_payload...)
+
+		default:
+			return fmt.Errorf("DNSRR.ToBytes: unsupported payload type %T", payload)
+	}
+
+	rdlen := len(*buf) - rdata_start
+	if rdlen > 0xFFFF {
+		return fmt.Errorf("DNSRR.ToBytes: rdata too large (%d bytes)", rdlen)
+	}
+	(*buf)[rdlen_pos] = byte(rdlen >> 8)
+	(*buf)[rdlen_pos+1] = byte(rdlen)
+
+	return nil
+}
+
+// DNSMessage
+
+// EDNSOption
+
+func (o *EDNSOption) ToBytes(buf *[]byte) {
+	*buf = append(*buf, byte(o.Code>>8), byte(o.Code))
+
+	len_pos := len(*buf)
+	*buf = append(*buf, 0, 0) // placeholder, patched below
+
+	data_start := len(*buf)
+
+	switch payload := o.Payload.(type) {
+		case EDNSSubnetOption:
+			*buf = append(*buf, byte(payload.Family>>8), byte(payload.Family))
+			*buf = append(*buf, payload.SourceNetmask, payload.ScopeNetmask)
+			*buf = append(*buf, payload.Address...)
+
+		case EDNSNSIDOption:
+			*buf = append(*buf, []byte(payload.Text)...)
+
+		default:
+			*buf = append(*buf, o.raw_data...)
+	}
+
+	olen := len(*buf) - data_start
+	(*buf)[len_pos] = byte(olen >> 8)
+	(*buf)[len_pos+1] = byte(olen)
+}
+
+func (m *DNSMessage) ToBytes() ([]byte, error) {
+	h := m.Header
+	h.QuestionCount = uint16(len(m.Question))
+	h.AnswerCount = uint16(len(m.Answer))
+	h.AuthorityCount = uint16(len(m.Authority))
+	h.AdditionalCount = uint16(len(m.Additional))
+
+	buf := []byte{}
+	comp := newNameCompressor()
+
+	h.ToBytes(&buf)
+
+	for i := range m.Question {
+		m.Question[i].ToBytes(&buf, comp)
+	}
+
+	for _, rrs := range [][]DNSRR{m.Answer, m.Authority, m.Additional} {
+		for i := range rrs {
+			if err := rrs[i].ToBytes(&buf, comp); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf, nil
+}
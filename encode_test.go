@@ -0,0 +1,131 @@
+package main
+
+/*
+	Round-trip tests for ToBytes() (encode.go) against captured-style mDNS
+	packets: a PTR query and a PTR/SRV/A response exercising name
+	compression across the answer and additional sections (RFC1035:4.1.4).
+*/
+
+import (
+	"bytes"
+	"testing"
+)
+
+// capturedQuery is a PTR query for "_http._tcp.local.".
+var capturedQuery = []byte{
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x05, 0x5f, 0x68, 0x74, 0x74, 0x70, 0x04, 0x5f, 0x74, 0x63, 0x70, 0x05,
+	0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x00, 0x00, 0x0c, 0x00, 0x01,
+}
+
+// capturedResponse is an authoritative response with one PTR answer and
+// SRV/A additional records, compressed the way a real mDNS responder emits.
+var capturedResponse = []byte{
+	0x00, 0x00, 0x84, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02,
+	0x05, 0x5f, 0x68, 0x74, 0x74, 0x70, 0x04, 0x5f, 0x74, 0x63, 0x70, 0x05,
+	0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x00, 0x00, 0x0c, 0x00, 0x01, 0x00, 0x00,
+	0x00, 0x78, 0x00, 0x0d, 0x0a, 0x4d, 0x79, 0x20, 0x50, 0x72, 0x69, 0x6e,
+	0x74, 0x65, 0x72, 0xc0, 0x0c, 0xc0, 0x28, 0x00, 0x21, 0x00, 0x01, 0x00,
+	0x00, 0x00, 0x78, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x02, 0x77, 0x07,
+	0x70, 0x72, 0x69, 0x6e, 0x74, 0x65, 0x72, 0xc0, 0x17, 0xc0, 0x47, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x78, 0x00, 0x04, 0xc0, 0xa8, 0x01,
+	0x32,
+}
+
+// capturedTXT is a response with a single TXT answer whose rdlen exactly
+// fills its three character-strings with no terminating zero byte - the case
+// parse_labels()'s bounded (!allow_ptr) branch must treat as success.
+var capturedTXT = []byte{
+	0x00, 0x00, 0x84, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+	0x0a, 0x4d, 0x79, 0x20, 0x50, 0x72, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x05,
+	0x5f, 0x68, 0x74, 0x74, 0x70, 0x04, 0x5f, 0x74, 0x63, 0x70, 0x05, 0x6c,
+	0x6f, 0x63, 0x61, 0x6c, 0x00, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00,
+	0x78, 0x00, 0x1d, 0x09, 0x74, 0x78, 0x74, 0x76, 0x65, 0x72, 0x73, 0x3d,
+	0x31, 0x0a, 0x74, 0x79, 0x3d, 0x45, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65,
+	0x07, 0x50, 0x72, 0x69, 0x6e, 0x74, 0x65, 0x72,
+}
+
+// assertRoundTrip parses "captured", re-encodes it, and checks the result is
+// byte-identical - i.e. ToBytes() is a faithful inverse of FromBytes() for
+// messages already using the compression it would itself produce.
+func assertRoundTrip(t *testing.T, captured []byte) {
+	t.Helper()
+
+	m := DNSMessage{}
+	if err := m.FromBytes(captured); err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+
+	encoded, err := m.ToBytes()
+	if err != nil {
+		t.Fatalf("ToBytes: %v", err)
+	}
+
+	if !bytes.Equal(encoded, captured) {
+		t.Fatalf("round trip mismatch:\n got: % x\nwant: % x", encoded, captured)
+	}
+
+	// The re-encoded bytes must also parse without error.
+	m2 := DNSMessage{}
+	if err := m2.FromBytes(encoded); err != nil {
+		t.Fatalf("FromBytes(re-encoded): %v", err)
+	}
+}
+
+func TestRoundTripQuery(t *testing.T) {
+	assertRoundTrip(t, capturedQuery)
+
+	m := DNSMessage{}
+	if err := m.FromBytes(capturedQuery); err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+	if len(m.Question) != 1 || m.Question[0].Name != "_http._tcp.local" || m.Question[0].Type != PTR {
+		t.Fatalf("unexpected question: %+v", m.Question)
+	}
+}
+
+func TestRoundTripResponse(t *testing.T) {
+	assertRoundTrip(t, capturedResponse)
+
+	m := DNSMessage{}
+	if err := m.FromBytes(capturedResponse); err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+
+	if len(m.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(m.Answer))
+	}
+	ptr, ok := m.Answer[0].Payload.(DNSPayloadPtr)
+	if !ok || ptr.Text != "My Printer._http._tcp.local" {
+		t.Fatalf("unexpected PTR payload: %+v", m.Answer[0].Payload)
+	}
+
+	if len(m.Additional) != 2 {
+		t.Fatalf("expected 2 additional records, got %d", len(m.Additional))
+	}
+	srv, ok := m.Additional[0].Payload.(DNSPayloadSrv)
+	if !ok || srv.Port != 631 || srv.Text != "printer local" {
+		t.Fatalf("unexpected SRV payload: %+v", m.Additional[0].Payload)
+	}
+	a, ok := m.Additional[1].Payload.(DNSPayloadA)
+	if !ok || a.IP.String() != "192.168.1.50" {
+		t.Fatalf("unexpected A payload: %+v", m.Additional[1].Payload)
+	}
+}
+
+func TestRoundTripTXT(t *testing.T) {
+	assertRoundTrip(t, capturedTXT)
+
+	m := DNSMessage{}
+	if err := m.FromBytes(capturedTXT); err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+
+	if len(m.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(m.Answer))
+	}
+	txt, ok := m.Answer[0].Payload.(DNSPayloadTxt)
+	if !ok || txt.Text != "txtvers=1 ty=Example Printer" {
+		t.Fatalf("unexpected TXT payload: %+v", m.Answer[0].Payload)
+	}
+}
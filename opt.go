@@ -0,0 +1,134 @@
+package main
+
+/*
+	EDNS(0) OPT pseudo-RR (RFC6891). An OPT record repurposes the CLASS and
+	TTL fields of a normal RR header: CLASS carries the sender's UDP payload
+	size, and TTL packs {extended-rcode, version, flags (incl. the DO bit)}.
+	Its rdata is a sequence of {option-code, option-length, option-data}.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	// EDNS0 option codes (RFC6891:6.1.2 IANA registry)
+	EDNS0_NSID   uint16 = 3 // RFC5001
+	EDNS0_SUBNET uint16 = 8 // RFC7871
+)
+
+var EDNSOptionToString = map[uint16]string{
+	EDNS0_NSID:   "NSID",
+	EDNS0_SUBNET: "SUBNET",
+}
+
+// RFC7871:6 - client/server subnet hint for geo-aware responses
+
+type EDNSSubnetOption struct {
+	Family        uint16
+	SourceNetmask uint8
+	ScopeNetmask  uint8
+	Address       net.IP
+}
+
+// RFC5001 - opaque nameserver identifier, conventionally printable text
+
+type EDNSNSIDOption struct {
+	Text string
+}
+
+// A single EDNS0 option; Payload holds a decoded EDNSSubnetOption or
+// EDNSNSIDOption for recognised codes, and is nil (with raw_data populated)
+// otherwise.
+
+type EDNSOption struct {
+	Code    uint16
+	Payload interface{}
+
+	raw_data []byte
+}
+
+func (o *EDNSOption) FromBytes(i, max_i int, b []byte) (int, error) {
+	if i+4 > max_i {
+		return 0, fmt.Errorf("EDNSOption.FromBytes: truncated option header at offset %d", i)
+	}
+
+	o.Code = binary.BigEndian.Uint16(b[i:])
+	i += 2
+
+	olen := int(binary.BigEndian.Uint16(b[i:]))
+	i += 2
+
+	if i+olen > max_i {
+		return 0, fmt.Errorf("EDNSOption.FromBytes: option data of length %d at offset %d exceeds bound %d", olen, i, max_i)
+	}
+
+	o.raw_data = b[i:i+olen]
+
+	switch o.Code {
+		case EDNS0_SUBNET:
+			if olen < 4 {
+				return 0, fmt.Errorf("EDNSOption.FromBytes: SUBNET option too short (%d bytes)", olen)
+			}
+			addr := make(net.IP, olen-4)
+			copy(addr, b[i+4:i+olen])
+			o.Payload = EDNSSubnetOption{
+				Family:        binary.BigEndian.Uint16(b[i:]),
+				SourceNetmask: b[i+2],
+				ScopeNetmask:  b[i+3],
+				Address:       addr,
+			}
+
+		case EDNS0_NSID:
+			o.Payload = EDNSNSIDOption{Text: string(o.raw_data)}
+	}
+
+	i += olen
+
+	return i, nil
+}
+
+// DNSPayloadOPT is the decoded form of an OPT RR; UDPSize/ExtRcode/Version/DO
+// are unpacked from the header's CLASS and TTL fields (RFC6891:6.1.3).
+
+type DNSPayloadOPT struct {
+	UDPSize  uint16
+	ExtRcode uint8
+	Version  uint8
+	DO       bool
+	Options  []EDNSOption
+}
+
+func (p *DNSPayloadOPT) FromBytes(i, max_i int, b []byte, class uint16, ttl uint32) error {
+	p.UDPSize = class
+	p.ExtRcode = uint8(ttl >> 24)
+	p.Version = uint8(ttl >> 16)
+	p.DO = (uint16(ttl) & 0x8000) != 0
+
+	for i < max_i {
+		opt := EDNSOption{}
+		var err error
+		if i, err = opt.FromBytes(i, max_i, b); err != nil { return err }
+		p.Options = append(p.Options, opt)
+	}
+
+	return nil
+}
+
+// Rcode returns the message's effective response code: the header's 4-bit
+// RFC1035 rcode, extended with an OPT record's 8-bit ExtRcode when present
+// (RFC6891:6.1.3).
+
+func (m *DNSMessage) Rcode() uint16 {
+	rc := Rcode(m.Header.Flags)
+
+	for _, rr := range m.Additional {
+		if opt, ok := rr.Payload.(DNSPayloadOPT); ok {
+			return (uint16(opt.ExtRcode) << 4) | rc
+		}
+	}
+
+	return rc
+}
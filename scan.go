@@ -24,6 +24,10 @@ var (
 	list_  = flag.Bool("list", false, "List system interfaces then exit.")
 	timeout_  = flag.Int("timeout", 0, "Duration of scan in seconds (default: 0, no timeout).")
 	interfaces_  = flag.String("interfaces", "", "Comma separated list of interfaces to use (default: all, see \"list\").")
+
+	ndjson_  = flag.String("ndjson", "", "Write NDJSON records to this file, one object per message (default: disabled).")
+	pcap_  = flag.String("pcap", "", "Write reconstructed UDP/IP frames to this pcap file, replacing any existing file (default: disabled).")
+	metrics_addr_  = flag.String("metrics", "", "Serve Prometheus metrics on this address, e.g. ':9153' (default: disabled).")
 )
 
 
@@ -86,6 +90,7 @@ type PacketConnWrapper interface {
 	// Hide ipv4- / ipv6-specific input or output
 	SetControlMessageWrapper() error
 	ReadFromWrapper([]byte) (int, net.Addr, int, net.IP, net.IP, error)
+	WriteToWrapper([]byte, net.Addr) (int, error)
 }
 
 // Can't add methods to non-local structures - define new structs, and embed
@@ -103,6 +108,9 @@ func (pcw IPv4PacketConnWrapper) ReadFromWrapper(b []byte) (int, net.Addr, int,
 	if err != nil { return 0, nil, 0, nil, nil, err }
 	return n, peer, cm.IfIndex, cm.Src, cm.Dst, err
 }
+func (pcw IPv4PacketConnWrapper) WriteToWrapper(b []byte, dst net.Addr) (int, error) {
+	return pcw.WriteTo(b, nil, dst) // nil control message: let the kernel pick the outgoing interface
+}
 
 // IPv6 wrapper implementation methods
 
@@ -114,6 +122,9 @@ func (pcw IPv6PacketConnWrapper) ReadFromWrapper(b []byte) (int, net.Addr, int,
 	if err != nil { return 0, nil, 0, nil, nil, err }
 	return n, peer, cm.IfIndex, cm.Src, cm.Dst, err
 }
+func (pcw IPv6PacketConnWrapper) WriteToWrapper(b []byte, dst net.Addr) (int, error) {
+	return pcw.WriteTo(b, nil, dst) // nil control message: let the kernel pick the outgoing interface
+}
 
 // IPv4/v6 agnostic message loop via PacketConnWrapper interface
 
@@ -123,7 +134,8 @@ func msg_loop(
 	mdns_addr *net.UDPAddr,
 	ifaces []net.Interface,
 	stop_channel chan bool,
-	dnsi_channel chan DNSMsgInfo) {
+	dnsi_channel chan DNSMsgInfo,
+	send_channel chan []byte) { // outgoing queries; nil if this loop is receive-only
 
 	var err error
 	
@@ -166,6 +178,11 @@ func msg_loop(
 				fmt.Printf("message loop closing for group %s\n", mdns_addr)
 				return
 
+			case data := <-send_channel: // nil send_channel is never selected
+				if _, err = p.WriteToWrapper(data, mdns_addr); err != nil {
+					fmt.Printf("Unable to send query to %s: %v\n", mdns_addr, err)
+				}
+
 			default:
 				p.SetReadDeadline(time.Now().Add(time.Second*read_timeout_s))
 
@@ -191,7 +208,10 @@ func msg_loop(
 					src: src,
 					dst: dst,
 				}
-				dnsi.msg.FromBytes(b[:n])
+				if err := dnsi.msg.FromBytes(b[:n]); err != nil {
+					fmt.Printf("Discarding malformed message from %s: %v\n", peer, err)
+					continue
+				}
 				dnsi_channel<- dnsi
 		}
 	}
@@ -205,15 +225,16 @@ func ip4_msg_loop(
 	mdns_addr *net.UDPAddr,
 	ifaces []net.Interface,
 	stop_channel chan bool,
-	dnsi_channel chan DNSMsgInfo) {
-	
+	dnsi_channel chan DNSMsgInfo,
+	send_channel chan []byte) {
+
 	// Listen for UDP packets, bound on the specified address/port
 	c, err := net.ListenUDP("udp4", listen_addr)
 	if(err != nil) { log.Fatal(err) }
 	defer c.Close()
 
 	pcw := IPv4PacketConnWrapper { ipv4.NewPacketConn(c) }
-	msg_loop(pcw, listen_addr, mdns_addr, ifaces, stop_channel, dnsi_channel)
+	msg_loop(pcw, listen_addr, mdns_addr, ifaces, stop_channel, dnsi_channel, send_channel)
 
 	/*
 	p := ipv4.NewPacketConn(c)
@@ -295,15 +316,16 @@ func ip6_msg_loop(
 	mdns_addr *net.UDPAddr,
 	ifaces []net.Interface,
 	stop_channel chan bool,
-	dnsi_channel chan DNSMsgInfo) {
-	
+	dnsi_channel chan DNSMsgInfo,
+	send_channel chan []byte) {
+
 	// Listen for UDP packets, bound on the specified address/port
 	c, err := net.ListenUDP("udp6", listen_addr)
 	if(err != nil) { log.Fatal(err) }
 	defer c.Close()
 
 	pcw := IPv6PacketConnWrapper { ipv6.NewPacketConn(c) }
-	msg_loop(pcw, listen_addr, mdns_addr, ifaces, stop_channel, dnsi_channel)
+	msg_loop(pcw, listen_addr, mdns_addr, ifaces, stop_channel, dnsi_channel, send_channel)
 
 	/*
 	p := ipv6.NewPacketConn(c)
@@ -382,6 +404,11 @@ func ip6_msg_loop(
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		if err := runClient(os.Args[2:]); err != nil { log.Fatal(err) }
+		return
+	}
+
 	var all_ifaces, ifaces []net.Interface
 	var err error
 
@@ -435,6 +462,29 @@ func main() {
 		return
 	}
 
+	// Build the list of sinks the received messages will fan out to; the
+	// human-readable printer is always active, others are opt-in via flags.
+
+	sinks := []Sink{ PrinterSink{} }
+
+	if *ndjson_ != "" {
+		s, err := NewNDJSONSink(*ndjson_)
+		if(err != nil) { log.Fatal(err) }
+		sinks = append(sinks, s)
+	}
+
+	if *pcap_ != "" {
+		s, err := NewPcapSink(*pcap_)
+		if(err != nil) { log.Fatal(err) }
+		sinks = append(sinks, s)
+	}
+
+	if *metrics_addr_ != "" {
+		s, err := NewPromSink(*metrics_addr_)
+		if(err != nil) { log.Fatal(err) }
+		sinks = append(sinks, s)
+	}
+
 	// Channels to:
 	// - collect message loop output
 	// - signal message loops to stop
@@ -453,7 +503,7 @@ func main() {
 		mDNSAddr4, err := net.ResolveUDPAddr("udp4", "224.0.0.251:5353")
 		if err != nil { log.Fatal(err) }
 
-		ip4_msg_loop(mDNSAddr4, mDNSAddr4, ifaces, stop_chan, mdns_chan)
+		ip4_msg_loop(mDNSAddr4, mDNSAddr4, ifaces, stop_chan, mdns_chan, nil)
 	}()
 	
 	wait_group.Add(1)
@@ -463,7 +513,7 @@ func main() {
 		mDNSAddr6, err := net.ResolveUDPAddr("udp6", "[ff02::fb]:5353")
 		if err != nil { log.Fatal(err) }
 
-		ip6_msg_loop(mDNSAddr6, mDNSAddr6, ifaces, stop_chan, mdns_chan)
+		ip6_msg_loop(mDNSAddr6, mDNSAddr6, ifaces, stop_chan, mdns_chan, nil)
 	}()
 
 	// Install signal handler and timeout
@@ -492,10 +542,9 @@ func main() {
 				should_quit = true
 
 			case dnsi := <-mdns_chan:
-				fmt.Printf("%+s -> %+s (from peer %s, intf=%s)\n",
-					dnsi.src, dnsi.dst,
-					dnsi.peer, dnsi.iface.Name )
-				dnsi.msg.Print()
+				for _, sink := range sinks {
+					sink.OnMessage(dnsi)
+				}
 		}
 
 		if should_quit { break }
@@ -526,4 +575,8 @@ func main() {
 	fmt.Println("Closing message loop output channel")
 	close(mdns_chan)
 	fmt.Println("Message loop output channel closed")
+
+	for _, sink := range sinks {
+		sink.Close()
+	}
 }
@@ -0,0 +1,360 @@
+package main
+
+/*
+	Pluggable consumers of the message stream coming out of msg_loop. main()
+	fans each DNSMsgInfo out to every configured Sink instead of hard-coding
+	a single presentation, so e.g. human-readable output, NDJSON logging, a
+	pcap capture and Prometheus metrics can all run from one process.
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Sink interface {
+	OnMessage(DNSMsgInfo)
+	Close()
+}
+
+// PrinterSink reproduces the original human-readable stdout output.
+
+type PrinterSink struct{}
+
+func (PrinterSink) OnMessage(dnsi DNSMsgInfo) {
+	fmt.Printf("%+s -> %+s (from peer %s, intf=%s)\n",
+		dnsi.src, dnsi.dst, dnsi.peer, dnsi.iface.Name)
+	dnsi.msg.Print()
+}
+
+func (PrinterSink) Close() {}
+
+// NDJSONSink writes one JSON object per message to a file, suitable for
+// offline analysis or tailing into a log pipeline.
+
+type ndjsonRR struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Class   string      `json:"class"`
+	TTL     uint32      `json:"ttl,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+type ndjsonRecord struct {
+	Time       time.Time  `json:"time"`
+	Iface      string     `json:"iface"`
+	Peer       string     `json:"peer"`
+	Src        string     `json:"src"`
+	Dst        string     `json:"dst"`
+	Header     string     `json:"header"`
+	Question   []ndjsonRR `json:"question,omitempty"`
+	Answer     []ndjsonRR `json:"answer,omitempty"`
+	Authority  []ndjsonRR `json:"authority,omitempty"`
+	Additional []ndjsonRR `json:"additional,omitempty"`
+	RawMsg     string     `json:"raw_msg"`
+}
+
+type NDJSONSink struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+	f  *os.File
+}
+
+func NewNDJSONSink(path string) (*NDJSONSink, error) {
+	f, err := os.Create(path)
+	if err != nil { return nil, fmt.Errorf("NewNDJSONSink: %w", err) }
+	return &NDJSONSink{w: bufio.NewWriter(f), f: f}, nil
+}
+
+func (s *NDJSONSink) OnMessage(dnsi DNSMsgInfo) {
+	rec := ndjsonRecord{
+		Time:       time.Now(),
+		Iface:      dnsi.iface.Name,
+		Peer:       dnsi.peer.String(),
+		Src:        dnsi.src.String(),
+		Dst:        dnsi.dst.String(),
+		Header:     dnsi.msg.Header.String(),
+		Question:   ndjsonQuestions(dnsi.msg.Question),
+		Answer:     ndjsonRRs(dnsi.msg.Answer),
+		Authority:  ndjsonRRs(dnsi.msg.Authority),
+		Additional: ndjsonRRs(dnsi.msg.Additional),
+		RawMsg:     base64.StdEncoding.EncodeToString(dnsi.msg.raw_msg),
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		fmt.Printf("NDJSONSink: unable to marshal record: %v\n", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(b)
+	s.w.WriteByte('\n')
+}
+
+func (s *NDJSONSink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Flush()
+	s.f.Close()
+}
+
+func ndjsonQuestions(qs []DNSRRHeader) []ndjsonRR {
+	var out []ndjsonRR
+	for _, h := range qs {
+		out = append(out, ndjsonRR{Name: h.Name, Type: TypeToString[h.Type], Class: ClassToString[h.Class]})
+	}
+	return out
+}
+
+func ndjsonRRs(rrs []DNSRR) []ndjsonRR {
+	var out []ndjsonRR
+	for _, rr := range rrs {
+		out = append(out, ndjsonRR{
+			Name:    rr.Header.Name,
+			Type:    TypeToString[rr.Header.Type],
+			Class:   ClassToString[rr.Header.Class],
+			TTL:     rr.TTL,
+			Payload: rr.Payload,
+		})
+	}
+	return out
+}
+
+// PcapSink appends each message, reconstructed as a raw IP/UDP frame, to a
+// classic libpcap capture file (DLT_RAW, so Wireshark infers IPv4 vs IPv6
+// from the leading nibble).
+
+const (
+	pcap_magic          = 0xa1b2c3d4
+	pcap_version_major  = 2
+	pcap_version_minor  = 4
+	pcap_snaplen        = 65535
+	pcap_linktype_raw   = 101 // DLT_RAW
+	mdns_port           = 5353
+)
+
+type PcapSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewPcapSink(path string) (*PcapSink, error) {
+	f, err := os.Create(path)
+	if err != nil { return nil, fmt.Errorf("NewPcapSink: %w", err) }
+
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], pcap_magic)
+	binary.LittleEndian.PutUint16(hdr[4:], pcap_version_major)
+	binary.LittleEndian.PutUint16(hdr[6:], pcap_version_minor)
+	binary.LittleEndian.PutUint32(hdr[16:], pcap_snaplen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcap_linktype_raw)
+
+	if _, err := f.Write(hdr); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("NewPcapSink: %w", err)
+	}
+
+	return &PcapSink{f: f}, nil
+}
+
+func (s *PcapSink) OnMessage(dnsi DNSMsgInfo) {
+	frame, err := buildIPUDPFrame(dnsi)
+	if err != nil {
+		fmt.Printf("PcapSink: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	rec_hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec_hdr[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(rec_hdr[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec_hdr[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(rec_hdr[12:], uint32(len(frame)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.Write(rec_hdr)
+	s.f.Write(frame)
+}
+
+func (s *PcapSink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.Close()
+}
+
+// buildIPUDPFrame reconstructs a raw IPv4/UDP or IPv6/UDP frame (no link
+// layer) around the original mDNS payload, using the source/destination
+// addresses msg_loop already extracted from the packet's control message.
+
+func buildIPUDPFrame(dnsi DNSMsgInfo) ([]byte, error) {
+	payload := dnsi.msg.raw_msg
+
+	src_port := uint16(mdns_port)
+	if udp_addr, ok := dnsi.peer.(*net.UDPAddr); ok {
+		src_port = uint16(udp_addr.Port)
+	}
+
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], src_port)
+	binary.BigEndian.PutUint16(udp[2:], mdns_port)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[8:], payload)
+
+	if ip4, dst4 := dnsi.src.To4(), dnsi.dst.To4(); ip4 != nil && dst4 != nil {
+		hdr := make([]byte, 20)
+		hdr[0] = 0x45 // version 4, IHL 5 (no options)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(hdr)+len(udp)))
+		hdr[8] = 64 // TTL
+		hdr[9] = 17 // protocol: UDP
+		copy(hdr[12:16], ip4)
+		copy(hdr[16:20], dst4)
+		binary.BigEndian.PutUint16(hdr[10:], internetChecksum(hdr))
+
+		return append(hdr, udp...), nil
+	}
+
+	ip6, dst6 := dnsi.src.To16(), dnsi.dst.To16()
+	if ip6 == nil || dst6 == nil {
+		return nil, fmt.Errorf("buildIPUDPFrame: unrecognised address family (src=%v dst=%v)", dnsi.src, dnsi.dst)
+	}
+
+	// RFC8200:8.1 - the UDP checksum is mandatory over IPv6
+	binary.BigEndian.PutUint16(udp[6:], udpChecksum6(ip6, dst6, udp))
+
+	hdr := make([]byte, 40)
+	hdr[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(hdr[4:], uint16(len(udp))) // payload length
+	hdr[6] = 17 // next header: UDP
+	hdr[7] = 64 // hop limit
+	copy(hdr[8:24], ip6)
+	copy(hdr[24:40], dst6)
+
+	return append(hdr, udp...), nil
+}
+
+func internetChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+func udpChecksum6(src, dst net.IP, udp []byte) uint16 {
+	pseudo := make([]byte, 0, 40+len(udp))
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(udp)))
+	pseudo = append(pseudo, length...)
+	pseudo = append(pseudo, 0, 0, 0, 17) // zero padding + next header: UDP
+	pseudo = append(pseudo, udp...)
+
+	sum := internetChecksum(pseudo)
+	if sum == 0 { sum = 0xFFFF } // RFC768: a computed zero is sent as all-ones
+	return sum
+}
+
+// PromSink exposes a Prometheus /metrics endpoint summarising traffic seen
+// so far: message counts, RR counts, and a gauge of unique service
+// instances (from PTR record targets).
+
+type PromSink struct {
+	mu        sync.Mutex
+	messages  map[string]uint64 // "iface|type" -> count
+	records   map[string]uint64 // "type|class" -> count
+	instances map[string]bool   // unique PTR targets seen
+
+	srv *http.Server
+}
+
+func NewPromSink(addr string) (*PromSink, error) {
+	s := &PromSink{
+		messages:  make(map[string]uint64),
+		records:   make(map[string]uint64),
+		instances: make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	s.srv = &http.Server{Addr: addr, Handler: mux}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil { return nil, fmt.Errorf("NewPromSink: %w", err) }
+
+	go s.srv.Serve(ln)
+
+	return s, nil
+}
+
+func (s *PromSink) OnMessage(dnsi DNSMsgInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg_type := "query"
+	if IsResponse(dnsi.msg.Header.Flags) { msg_type = "response" }
+	s.messages[dnsi.iface.Name+"|"+msg_type]++
+
+	for _, rrs := range [][]DNSRR{dnsi.msg.Answer, dnsi.msg.Authority, dnsi.msg.Additional} {
+		for _, rr := range rrs {
+			s.records[TypeToString[rr.Header.Type]+"|"+ClassToString[rr.Header.Class]]++
+			if ptr, ok := rr.Payload.(DNSPayloadPtr); ok {
+				s.instances[ptr.Text] = true
+			}
+		}
+	}
+}
+
+func (s *PromSink) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP mdns_messages_total Total mDNS messages observed.")
+	fmt.Fprintln(w, "# TYPE mdns_messages_total counter")
+	for key, n := range s.messages {
+		iface, typ := splitSinkKey(key)
+		fmt.Fprintf(w, "mdns_messages_total{iface=%q,type=%q} %d\n", iface, typ, n)
+	}
+
+	fmt.Fprintln(w, "# HELP mdns_records_total Total mDNS resource records observed.")
+	fmt.Fprintln(w, "# TYPE mdns_records_total counter")
+	for key, n := range s.records {
+		typ, class := splitSinkKey(key)
+		fmt.Fprintf(w, "mdns_records_total{type=%q,class=%q} %d\n", typ, class, n)
+	}
+
+	fmt.Fprintln(w, "# HELP mdns_service_instances Unique service instances seen (via PTR targets).")
+	fmt.Fprintln(w, "# TYPE mdns_service_instances gauge")
+	fmt.Fprintf(w, "mdns_service_instances %d\n", len(s.instances))
+}
+
+func splitSinkKey(key string) (string, string) {
+	idx := strings.IndexByte(key, '|')
+	if idx < 0 { return key, "" }
+	return key[:idx], key[idx+1:]
+}
+
+func (s *PromSink) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	s.srv.Shutdown(ctx)
+}
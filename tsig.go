@@ -0,0 +1,119 @@
+package main
+
+/*
+	TSIG (RFC2845): signs a DNS message with a shared secret so a server can
+	authenticate e.g. an RFC2136 dynamic update. We implement HMAC-SHA256
+	only; the TSIG RR's name and Algorithm Name are never compressed
+	(RFC2845:2.3), so this builds their wire bytes independently of
+	nameCompressor.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	TSIG uint16 = 250 // RFC2845:2
+
+	tsig_alg_hmac_sha256 = "hmac-sha256."
+	tsig_fudge           = 300 // Fudge field: seconds of clock skew either side of TimeSigned the verifier may allow
+)
+
+// A shared secret used to sign outgoing messages; parsed from "-tsig name:base64secret".
+
+type TSIGKey struct {
+	Name   string
+	Secret []byte
+}
+
+func parseTSIGArg(s string) (*TSIGKey, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("client: -tsig must be \"name:base64secret\"")
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("client: -tsig secret is not valid base64: %w", err)
+	}
+
+	return &TSIGKey{Name: strings.TrimSuffix(parts[0], "."), Secret: secret}, nil
+}
+
+// appendName writes "name" as the uncompressed [len][bytes]... label
+// sequence parse_labels()/ToBytes() use, with no compression pointers -
+// required for the TSIG owner name and Algorithm Name (RFC2845:2.3).
+
+func appendName(buf *[]byte, name string) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			*buf = append(*buf, byte(len(label)))
+			*buf = append(*buf, []byte(label)...)
+		}
+	}
+	*buf = append(*buf, 0)
+}
+
+// signTSIG appends a TSIG RR to "unsigned" (an already-encoded message),
+// incrementing ARCOUNT, and returns the signed wire bytes. The MAC covers
+// the unsigned message plus the "TSIG Variables" (RFC2845:3.4.2) - it does
+// not cover the TSIG RR's own MAC/MAC-size/Original-ID fields.
+
+func signTSIG(unsigned []byte, id uint16, key *TSIGKey) ([]byte, error) {
+	if len(unsigned) < 12 {
+		return nil, fmt.Errorf("signTSIG: message too short to contain a header")
+	}
+
+	time_signed := uint64(time.Now().Unix())
+	fudge := uint16(tsig_fudge)
+
+	var variables []byte
+	appendName(&variables, key.Name)
+	variables = append(variables, byte(ANY>>8), byte(ANY))
+	variables = append(variables, 0, 0, 0, 0) // TTL - MUST be 0 (RFC2845:2.3)
+	appendName(&variables, tsig_alg_hmac_sha256)
+	variables = append48bitTime(variables, time_signed)
+	variables = append(variables, byte(fudge>>8), byte(fudge))
+	variables = append(variables, 0, 0) // Error
+	variables = append(variables, 0, 0) // Other Len (no Other Data)
+
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write(unsigned)
+	mac.Write(variables)
+	digest := mac.Sum(nil)
+
+	var rdata []byte
+	appendName(&rdata, tsig_alg_hmac_sha256)
+	rdata = append48bitTime(rdata, time_signed)
+	rdata = append(rdata, byte(fudge>>8), byte(fudge))
+	rdata = append(rdata, byte(len(digest)>>8), byte(len(digest)))
+	rdata = append(rdata, digest...)
+	rdata = append(rdata, byte(id>>8), byte(id)) // Original ID
+	rdata = append(rdata, 0, 0)                  // Error
+	rdata = append(rdata, 0, 0)                  // Other Len
+
+	var rr []byte
+	appendName(&rr, key.Name)
+	rr = append(rr, byte(TSIG>>8), byte(TSIG))
+	rr = append(rr, byte(ANY>>8), byte(ANY))
+	rr = append(rr, 0, 0, 0, 0) // TTL
+	rr = append(rr, byte(len(rdata)>>8), byte(len(rdata)))
+	rr = append(rr, rdata...)
+
+	signed := append([]byte{}, unsigned...)
+	arcount := binary.BigEndian.Uint16(signed[10:]) // RFC1035:4.1.1 header layout
+	binary.BigEndian.PutUint16(signed[10:], arcount+1)
+
+	return append(signed, rr...), nil
+}
+
+func append48bitTime(buf []byte, t uint64) []byte {
+	return append(buf, byte(t>>40), byte(t>>32), byte(t>>24), byte(t>>16), byte(t>>8), byte(t))
+}